@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CachePolicy opts a request into response caching, keyed by
+// method+URL+headers, for the given TTL.
+type CachePolicy struct {
+	TTL string `json:"ttl,omitempty"`
+}
+
+// ttl parses CachePolicy.TTL, returning 0 (always stale) if unset or
+// invalid.
+func (p *CachePolicy) ttl() time.Duration {
+	if p == nil || p.TTL == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(p.TTL)
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
+// cacheEntry is one cached response, along with the validators needed to
+// conditionally revalidate it once its TTL has lapsed.
+type cacheEntry struct {
+	StatusCode   int
+	Headers      map[string][]string
+	Body         string
+	BodyBase64   bool
+	ETag         string
+	LastModified string
+	storedAt     time.Time
+	ttl          time.Duration
+}
+
+// fresh reports whether e is still within its TTL. Callers must hold
+// (at least) the owning Cache's RLock, since storedAt is also written by
+// Set/Touch under its Lock — use Cache.Lookup rather than calling this
+// directly on an entry pointer obtained after the lock was released.
+func (e *cacheEntry) fresh() bool {
+	return time.Since(e.storedAt) < e.ttl
+}
+
+// CacheStats is the summary exposed on /stats.
+type CacheStats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+	Size   int   `json:"size"`
+}
+
+// Cache is a small in-memory response cache keyed by method+URL+headers.
+type Cache struct {
+	mu      sync.RWMutex
+	entries map[string]*cacheEntry
+	hits    int64
+	misses  int64
+}
+
+// NewCache builds an empty Cache.
+func NewCache() *Cache {
+	return &Cache{entries: make(map[string]*cacheEntry)}
+}
+
+// Lookup returns a value copy of the entry for key, if any, along with
+// whether it was fresh as of that snapshot. Both are read under the same
+// RLock so they can't race with a concurrent Set/Touch writing storedAt
+// on the same entry; callers must not go back to the map for the live
+// *cacheEntry afterward.
+func (c *Cache) Lookup(key string) (entry cacheEntry, fresh bool, found bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return cacheEntry{}, false, false
+	}
+	return *e, e.fresh(), true
+}
+
+// Set stores entry under key with the given TTL, stamping its storage
+// time.
+func (c *Cache) Set(key string, entry *cacheEntry, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry.storedAt = time.Now()
+	entry.ttl = ttl
+	c.entries[key] = entry
+}
+
+// Touch refreshes an existing entry's storage time, used after a 304 Not
+// Modified revalidation.
+func (c *Cache) Touch(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[key]; ok {
+		entry.storedAt = time.Now()
+	}
+}
+
+func (c *Cache) recordHit() { atomic.AddInt64(&c.hits, 1) }
+
+func (c *Cache) recordMiss() { atomic.AddInt64(&c.misses, 1) }
+
+// Stats reports cache hit/miss counters and current entry count.
+func (c *Cache) Stats() CacheStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return CacheStats{
+		Hits:   atomic.LoadInt64(&c.hits),
+		Misses: atomic.LoadInt64(&c.misses),
+		Size:   len(c.entries),
+	}
+}
+
+// globalCache backs every config's cache: opt-in, so entries naturally
+// accumulate across configs that hit the same idempotent upstreams.
+var globalCache = NewCache()
+
+// cacheKey identifies a cacheable request by method, URL, and its
+// configured headers.
+func cacheKey(config RequestConfig) string {
+	var b strings.Builder
+	b.WriteString(config.Method)
+	b.WriteByte(' ')
+	b.WriteString(config.URL)
+
+	keys := make([]string, 0, len(config.Headers))
+	for k := range config.Headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		b.WriteByte(' ')
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(config.Headers[k])
+	}
+
+	return b.String()
+}
+
+// resultFromCache builds a RequestResult from a cached entry snapshot.
+func resultFromCache(config RequestConfig, entry cacheEntry) RequestResult {
+	return RequestResult{
+		URL:        config.URL,
+		Method:     config.Method,
+		StatusCode: entry.StatusCode,
+		Headers:    entry.Headers,
+		Body:       entry.Body,
+		BodyBase64: entry.BodyBase64,
+		BytesRead:  len(entry.Body),
+	}
+}
+
+// isCacheableMethod reports whether method is idempotent enough to cache:
+// GET and HEAD (an empty method defaults to GET via http.NewRequest).
+func isCacheableMethod(method string) bool {
+	switch method {
+	case "", http.MethodGet, http.MethodHead:
+		return true
+	default:
+		return false
+	}
+}
+
+// isCacheableResponse reports whether result is eligible to be stored:
+// only successful responses that don't declare Cache-Control: no-store.
+func isCacheableResponse(result RequestResult) bool {
+	if result.StatusCode < 200 || result.StatusCode >= 300 {
+		return false
+	}
+	return !cacheControlNoStore(result.Headers)
+}
+
+// cacheControlNoStore reports whether headers carry a Cache-Control:
+// no-store directive, scanning case-insensitively since captured response
+// headers aren't guaranteed to be canonicalized.
+func cacheControlNoStore(headers map[string][]string) bool {
+	for k, values := range headers {
+		if !strings.EqualFold(k, "Cache-Control") {
+			continue
+		}
+		for _, v := range values {
+			for _, directive := range strings.Split(v, ",") {
+				if strings.EqualFold(strings.TrimSpace(directive), "no-store") {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// cachedRequest wraps attemptRequest with the response cache: a fresh hit
+// is served without touching the upstream, a stale entry is revalidated
+// with conditional headers, and a successful, cacheable response is
+// (re)cached. Non-idempotent methods (anything but GET/HEAD) always
+// bypass the cache, and 4xx/5xx or Cache-Control: no-store responses are
+// never stored, so a failing upstream can't be "cached" and served back
+// for the rest of its TTL (including to retry_on, which would otherwise
+// burn every retry re-serving the same cached failure).
+func cachedRequest(config RequestConfig, start time.Time) (RequestResult, bool, error) {
+	if config.Cache == nil || !isCacheableMethod(config.Method) {
+		return attemptRequest(config, start)
+	}
+
+	key := cacheKey(config)
+
+	entry, fresh, found := globalCache.Lookup(key)
+	if found && fresh {
+		globalCache.recordHit()
+		result := resultFromCache(config, entry)
+		result.ElapsedMS = time.Since(start).Milliseconds()
+		return result, false, nil
+	}
+
+	globalCache.recordMiss()
+
+	revalidating := config
+	if found {
+		revalidating.Headers = make(map[string]string, len(config.Headers)+2)
+		for k, v := range config.Headers {
+			revalidating.Headers[k] = v
+		}
+		if entry.ETag != "" {
+			revalidating.Headers["If-None-Match"] = entry.ETag
+		}
+		if entry.LastModified != "" {
+			revalidating.Headers["If-Modified-Since"] = entry.LastModified
+		}
+	}
+
+	result, isNetworkErr, err := attemptRequest(revalidating, start)
+	if err != nil {
+		return result, isNetworkErr, err
+	}
+
+	if found && result.StatusCode == http.StatusNotModified {
+		globalCache.Touch(key)
+		cached := resultFromCache(config, entry)
+		cached.ElapsedMS = result.ElapsedMS
+		return cached, false, nil
+	}
+
+	if isCacheableResponse(result) {
+		newEntry := &cacheEntry{
+			StatusCode:   result.StatusCode,
+			Headers:      result.Headers,
+			Body:         result.Body,
+			BodyBase64:   result.BodyBase64,
+			ETag:         firstHeader(result.Headers, "Etag"),
+			LastModified: firstHeader(result.Headers, "Last-Modified"),
+		}
+		globalCache.Set(key, newEntry, config.Cache.ttl())
+	}
+
+	return result, isNetworkErr, nil
+}
+
+func firstHeader(headers map[string][]string, key string) string {
+	if headers == nil {
+		return ""
+	}
+	if values := headers[key]; len(values) > 0 {
+		return values[0]
+	}
+	// http.Header canonicalizes keys; response headers captured into a
+	// plain map may not be, so fall back to a case-insensitive scan.
+	for k, values := range headers {
+		if strings.EqualFold(k, key) && len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+// handleStats reports cache hit/miss/size counters as JSON.
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(globalCache.Stats())
+}
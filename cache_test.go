@@ -0,0 +1,176 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIsCacheableMethod(t *testing.T) {
+	cases := map[string]bool{
+		"":       true,
+		"GET":    true,
+		"HEAD":   true,
+		"POST":   false,
+		"PUT":    false,
+		"DELETE": false,
+	}
+	for method, want := range cases {
+		if got := isCacheableMethod(method); got != want {
+			t.Errorf("isCacheableMethod(%q) = %v, want %v", method, got, want)
+		}
+	}
+}
+
+func TestIsCacheableResponse(t *testing.T) {
+	cases := []struct {
+		name   string
+		result RequestResult
+		want   bool
+	}{
+		{"200 ok", RequestResult{StatusCode: 200}, true},
+		{"404 not cached", RequestResult{StatusCode: 404}, false},
+		{"500 not cached", RequestResult{StatusCode: 500}, false},
+		{
+			"no-store not cached",
+			RequestResult{StatusCode: 200, Headers: map[string][]string{"Cache-Control": {"no-store"}}},
+			false,
+		},
+		{
+			"no-store case-insensitive header name",
+			RequestResult{StatusCode: 200, Headers: map[string][]string{"cache-control": {"private, no-store"}}},
+			false,
+		},
+	}
+	for _, c := range cases {
+		if got := isCacheableResponse(c.result); got != c.want {
+			t.Errorf("%s: isCacheableResponse() = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+// TestCachedRequestDoesNotCacheErrorStatus guards against a cached 5xx
+// being served "fresh" for the rest of its TTL: every call must reach the
+// upstream, not just the first.
+func TestCachedRequestDoesNotCacheErrorStatus(t *testing.T) {
+	globalCache = NewCache()
+
+	var hits int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	config := RequestConfig{Method: http.MethodGet, URL: server.URL, Cache: &CachePolicy{TTL: "1m"}}
+
+	for i := 0; i < 3; i++ {
+		if _, _, err := cachedRequest(config, time.Now()); err != nil {
+			t.Fatalf("attempt %d: %v", i, err)
+		}
+	}
+
+	if got := atomic.LoadInt64(&hits); got != 3 {
+		t.Fatalf("upstream hit %d times, want 3 (a cached 5xx would stop after 1)", got)
+	}
+	if stats := globalCache.Stats(); stats.Size != 0 {
+		t.Fatalf("expected no cache entries for a 500 response, got %d", stats.Size)
+	}
+}
+
+// TestCachedRequestCachesSuccess verifies the opt-in happy path still
+// works: a 200 is cached and served without a second upstream hit.
+func TestCachedRequestCachesSuccess(t *testing.T) {
+	globalCache = NewCache()
+
+	var hits int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	config := RequestConfig{Method: http.MethodGet, URL: server.URL, Cache: &CachePolicy{TTL: "1m"}}
+
+	for i := 0; i < 3; i++ {
+		if _, _, err := cachedRequest(config, time.Now()); err != nil {
+			t.Fatalf("attempt %d: %v", i, err)
+		}
+	}
+
+	if got := atomic.LoadInt64(&hits); got != 1 {
+		t.Fatalf("upstream hit %d times, want 1 (later calls should be served from cache)", got)
+	}
+}
+
+// TestCacheLookupRaceWithTouch guards against the storedAt data race: a
+// reader calling Lookup concurrently with a writer calling Touch/Set on
+// the same key must never trip -race, since both now only touch
+// storedAt/ttl while holding the Cache's lock.
+func TestCacheLookupRaceWithTouch(t *testing.T) {
+	c := NewCache()
+	c.Set("k", &cacheEntry{StatusCode: 200, Body: "v"}, time.Minute)
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				c.Lookup("k")
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				c.Touch("k")
+			}
+		}
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+}
+
+// TestCachedRequestBypassesCacheForNonIdempotentMethod guards against
+// caching POST/PUT/etc responses: every call must reach the upstream.
+func TestCachedRequestBypassesCacheForNonIdempotentMethod(t *testing.T) {
+	globalCache = NewCache()
+
+	var hits int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	config := RequestConfig{Method: http.MethodPost, URL: server.URL, Cache: &CachePolicy{TTL: "1m"}}
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := cachedRequest(config, time.Now()); err != nil {
+			t.Fatalf("attempt %d: %v", i, err)
+		}
+	}
+
+	if got := atomic.LoadInt64(&hits); got != 2 {
+		t.Fatalf("upstream hit %d times, want 2 (POST must never be served from cache)", got)
+	}
+	if stats := globalCache.Stats(); stats.Size != 0 {
+		t.Fatalf("expected no cache entries for a POST, got %d", stats.Size)
+	}
+}
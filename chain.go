@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// isChained reports whether config opts into the sequential/DAG dispatch
+// mode, triggered by any request declaring an id.
+func isChained(config Config) bool {
+	for _, r := range config.Requests {
+		if r.ID != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// chainVars is the run-scoped variable map that extract rules write into
+// and {{.vars.*}} templates read from, shared across a single dispatch of
+// a chained config.
+type chainVars struct {
+	mu   sync.RWMutex
+	vars map[string]string
+}
+
+func newChainVars() *chainVars {
+	return &chainVars{vars: make(map[string]string)}
+}
+
+func (c *chainVars) set(key, value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.vars[key] = value
+}
+
+func (c *chainVars) snapshot() map[string]string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	snap := make(map[string]string, len(c.vars))
+	for k, v := range c.vars {
+		snap[k] = v
+	}
+	return snap
+}
+
+// renderField expands {{.vars.name}}-style placeholders in s.
+func renderField(s string, vars map[string]string) (string, error) {
+	if !strings.Contains(s, "{{") {
+		return s, nil
+	}
+
+	tmpl, err := template.New("field").Parse(s)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, map[string]interface{}{"vars": vars}); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// renderRequest returns a copy of config with its URL, headers, and body
+// templated against vars.
+func renderRequest(config RequestConfig, vars map[string]string) (RequestConfig, error) {
+	rendered := config
+	var err error
+
+	if rendered.URL, err = renderField(config.URL, vars); err != nil {
+		return config, fmt.Errorf("rendering url: %w", err)
+	}
+
+	if config.Headers != nil {
+		rendered.Headers = make(map[string]string, len(config.Headers))
+		for k, v := range config.Headers {
+			if rendered.Headers[k], err = renderField(v, vars); err != nil {
+				return config, fmt.Errorf("rendering header %q: %w", k, err)
+			}
+		}
+	}
+
+	if config.Body != nil {
+		rendered.Body = make(map[string]string, len(config.Body))
+		for k, v := range config.Body {
+			if rendered.Body[k], err = renderField(v, vars); err != nil {
+				return config, fmt.Errorf("rendering body field %q: %w", k, err)
+			}
+		}
+	}
+
+	return rendered, nil
+}
+
+// extractOne evaluates a single extract rule against a response body. A
+// "regex:<pattern>" rule applies the pattern to the raw body and returns
+// its first capture group; anything else is a dot-separated JSON field
+// path evaluated against the parsed body.
+func extractOne(body []byte, rule string) (string, error) {
+	if strings.HasPrefix(rule, "regex:") {
+		pattern := strings.TrimPrefix(rule, "regex:")
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return "", fmt.Errorf("compiling pattern %q: %w", pattern, err)
+		}
+		match := re.FindSubmatch(body)
+		if len(match) < 2 {
+			return "", fmt.Errorf("no match for pattern %q", pattern)
+		}
+		return string(match[1]), nil
+	}
+
+	var parsed interface{}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return "", fmt.Errorf("parsing response as JSON: %w", err)
+	}
+
+	for _, segment := range strings.Split(rule, ".") {
+		m, ok := parsed.(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("path %q: %q is not an object", rule, segment)
+		}
+		parsed, ok = m[segment]
+		if !ok {
+			return "", fmt.Errorf("path %q: field %q not found", rule, segment)
+		}
+	}
+
+	return fmt.Sprintf("%v", parsed), nil
+}
+
+// extractVars evaluates every extract rule on node against a response body
+// and binds the results into vars.
+func extractVars(node RequestConfig, body []byte, vars *chainVars) error {
+	for name, rule := range node.Extract {
+		value, err := extractOne(body, rule)
+		if err != nil {
+			return fmt.Errorf("extracting %q: %w", name, err)
+		}
+		vars.set(name, value)
+	}
+	return nil
+}
+
+// validateChain checks the invariants runChain's id-keyed wiring assumes:
+// every request needs a unique, non-empty id (so each gets its own done
+// channel to close), every depends_on must name another request in the
+// same config, and the depends_on graph must be acyclic.
+func validateChain(config Config) error {
+	byID := make(map[string]RequestConfig, len(config.Requests))
+	for _, r := range config.Requests {
+		if r.ID == "" {
+			return fmt.Errorf("chain mode requires every request to have a non-empty id")
+		}
+		if _, dup := byID[r.ID]; dup {
+			return fmt.Errorf("duplicate request id %q", r.ID)
+		}
+		byID[r.ID] = r
+	}
+
+	for _, r := range config.Requests {
+		for _, dep := range r.DependsOn {
+			if _, ok := byID[dep]; !ok {
+				return fmt.Errorf("request %q depends_on unknown id %q", r.ID, dep)
+			}
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(config.Requests))
+
+	var visit func(id string) error
+	visit = func(id string) error {
+		switch state[id] {
+		case visiting:
+			return fmt.Errorf("depends_on cycle detected at %q", id)
+		case visited:
+			return nil
+		}
+		state[id] = visiting
+		for _, dep := range byID[id].DependsOn {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		state[id] = visited
+		return nil
+	}
+
+	for _, r := range config.Requests {
+		if err := visit(r.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runChain topologically runs config.Requests as a DAG keyed by id and
+// depends_on: nodes with no unmet dependencies run in parallel, downstream
+// nodes are templated against variables extracted from their
+// dependencies' responses, and a failed node skips everything that
+// (transitively) depends on it. Each node runs through performRequest, so
+// a node's own policy (timeout, retries, retry_on, circuit_breaker) is
+// honored exactly as it would be outside chain mode. config is validated
+// up front so a duplicate/missing id or a dependency cycle is reported as
+// a single error result instead of panicking or deadlocking the
+// goroutines below.
+func runChain(config Config, limiter *Limiter, results chan<- RequestResult) {
+	if err := validateChain(config); err != nil {
+		results <- RequestResult{Error: fmt.Sprintf("invalid chain config: %v", err)}
+		close(results)
+		return
+	}
+
+	done := make(map[string]chan struct{}, len(config.Requests))
+	for _, r := range config.Requests {
+		done[r.ID] = make(chan struct{})
+	}
+
+	var failed sync.Map // id -> struct{}, for nodes that failed or were skipped
+	vars := newChainVars()
+	var wg sync.WaitGroup
+
+	for _, r := range config.Requests {
+		wg.Add(1)
+		go func(node RequestConfig) {
+			defer wg.Done()
+			defer close(done[node.ID])
+
+			for _, dep := range node.DependsOn {
+				if depDone, ok := done[dep]; ok {
+					<-depDone
+				}
+			}
+
+			for _, dep := range node.DependsOn {
+				if _, skipped := failed.Load(dep); skipped {
+					failed.Store(node.ID, struct{}{})
+					results <- RequestResult{URL: node.URL, Method: node.Method, Error: fmt.Sprintf("skipped: dependency %q failed", dep)}
+					return
+				}
+			}
+
+			start := time.Now()
+
+			rendered, err := renderRequest(node, vars.snapshot())
+			if err != nil {
+				failed.Store(node.ID, struct{}{})
+				results <- newErrorResult(node, start, err)
+				return
+			}
+
+			release := limiter.Acquire(hostOf(rendered.URL))
+			result := performRequest(rendered, start)
+			release()
+
+			if result.Error != "" {
+				failed.Store(node.ID, struct{}{})
+				results <- result
+				return
+			}
+
+			if result.StatusCode >= 400 {
+				failed.Store(node.ID, struct{}{})
+			} else if len(node.Extract) > 0 {
+				body := []byte(result.Body)
+				if result.BodyBase64 {
+					if decoded, err := base64.StdEncoding.DecodeString(result.Body); err == nil {
+						body = decoded
+					}
+				}
+				if err := extractVars(node, body, vars); err != nil {
+					failed.Store(node.ID, struct{}{})
+					result.Error = err.Error()
+				}
+			}
+
+			results <- result
+		}(r)
+	}
+
+	wg.Wait()
+	close(results)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestValidateChainDuplicateID(t *testing.T) {
+	config := Config{Requests: []RequestConfig{
+		{ID: "a", URL: "http://example.com/1"},
+		{ID: "a", URL: "http://example.com/2"},
+	}}
+
+	if err := validateChain(config); err == nil {
+		t.Fatal("expected an error for duplicate ids, got nil")
+	}
+}
+
+func TestValidateChainEmptyID(t *testing.T) {
+	config := Config{Requests: []RequestConfig{
+		{ID: "a", URL: "http://example.com/1"},
+		{URL: "http://example.com/2"},
+	}}
+
+	if err := validateChain(config); err == nil {
+		t.Fatal("expected an error for an empty id, got nil")
+	}
+}
+
+func TestValidateChainUnknownDependency(t *testing.T) {
+	config := Config{Requests: []RequestConfig{
+		{ID: "a", URL: "http://example.com/1", DependsOn: []string{"missing"}},
+	}}
+
+	if err := validateChain(config); err == nil {
+		t.Fatal("expected an error for an unknown depends_on id, got nil")
+	}
+}
+
+func TestValidateChainCycle(t *testing.T) {
+	config := Config{Requests: []RequestConfig{
+		{ID: "a", URL: "http://example.com/1", DependsOn: []string{"b"}},
+		{ID: "b", URL: "http://example.com/2", DependsOn: []string{"a"}},
+	}}
+
+	if err := validateChain(config); err == nil {
+		t.Fatal("expected an error for a depends_on cycle, got nil")
+	}
+}
+
+func TestValidateChainOK(t *testing.T) {
+	config := Config{Requests: []RequestConfig{
+		{ID: "a", URL: "http://example.com/1"},
+		{ID: "b", URL: "http://example.com/2", DependsOn: []string{"a"}},
+	}}
+
+	if err := validateChain(config); err != nil {
+		t.Fatalf("expected no error for a valid DAG, got %v", err)
+	}
+}
+
+// TestRunChainInvalidConfigDoesNotPanic guards against the duplicate-id
+// close-of-closed-channel panic: runChain must report an error result and
+// close the channel instead of spawning goroutines against a malformed
+// config.
+func TestRunChainInvalidConfigDoesNotPanic(t *testing.T) {
+	config := Config{Requests: []RequestConfig{
+		{ID: "a", URL: "http://example.com/1"},
+		{ID: "a", URL: "http://example.com/2"},
+	}}
+
+	results := make(chan RequestResult, 4)
+	limiter := NewLimiter(0, 0, 0)
+	defer limiter.Stop()
+
+	runChain(config, limiter, results)
+
+	result, ok := <-results
+	if !ok {
+		t.Fatal("expected one error result, got a closed channel")
+	}
+	if result.Error == "" {
+		t.Fatal("expected the result to carry the validation error")
+	}
+	if _, ok := <-results; ok {
+		t.Fatal("expected the results channel to be closed after the error")
+	}
+}
+
+// TestRunChainCycleDoesNotDeadlock guards against the depends_on cycle
+// hang: runChain must return instead of blocking goroutines on each
+// other's done channel forever.
+func TestRunChainCycleDoesNotDeadlock(t *testing.T) {
+	config := Config{Requests: []RequestConfig{
+		{ID: "a", URL: "http://example.com/1", DependsOn: []string{"b"}},
+		{ID: "b", URL: "http://example.com/2", DependsOn: []string{"a"}},
+	}}
+
+	results := make(chan RequestResult, 4)
+	limiter := NewLimiter(0, 0, 0)
+	defer limiter.Stop()
+
+	done := make(chan struct{})
+	go func() {
+		runChain(config, limiter, results)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("runChain deadlocked on a depends_on cycle")
+	}
+}
+
+// TestRunChainHonorsRetryPolicy guards against the DAG path silently
+// dropping policy.retries: a chained node whose upstream fails once and
+// then succeeds must be retried rather than reported as a single failed
+// attempt.
+func TestRunChainHonorsRetryPolicy(t *testing.T) {
+	var hits int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&hits, 1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	config := Config{Requests: []RequestConfig{
+		{
+			ID:     "a",
+			URL:    server.URL,
+			Method: http.MethodGet,
+			Policy: &Policy{Retries: 1, RetryOn: []string{"500"}},
+		},
+	}}
+
+	results := make(chan RequestResult, 4)
+	limiter := NewLimiter(0, 0, 0)
+	defer limiter.Stop()
+
+	runChain(config, limiter, results)
+
+	result, ok := <-results
+	if !ok {
+		t.Fatal("expected a result, got a closed channel")
+	}
+	if result.StatusCode != http.StatusOK {
+		t.Fatalf("expected the retried request to eventually succeed, got status %d (error %q)", result.StatusCode, result.Error)
+	}
+	if result.Attempts != 2 {
+		t.Fatalf("attempts = %d, want 2 (policy.retries wasn't honored in chain mode)", result.Attempts)
+	}
+	if got := atomic.LoadInt64(&hits); got != 2 {
+		t.Fatalf("upstream hit %d times, want 2", got)
+	}
+}
+
+// TestRunChainHonorsCircuitBreaker guards against the DAG path silently
+// dropping policy.circuit_breaker: a single failing chained node must
+// still open its host's breaker, exactly as it would via sendRequest.
+func TestRunChainHonorsCircuitBreaker(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	host := hostOf(server.URL)
+	breakerPolicy := &BreakerPolicy{FailureThreshold: 1, Cooldown: "1h"}
+	breakers.mu.Lock()
+	delete(breakers.breakers, host)
+	breakers.mu.Unlock()
+
+	config := Config{Requests: []RequestConfig{
+		{
+			ID:     "a",
+			URL:    server.URL,
+			Method: http.MethodGet,
+			Policy: &Policy{CircuitBreaker: breakerPolicy},
+		},
+	}}
+
+	results := make(chan RequestResult, 4)
+	limiter := NewLimiter(0, 0, 0)
+	defer limiter.Stop()
+
+	runChain(config, limiter, results)
+	for range results {
+	}
+
+	if cb := breakers.get(host, breakerPolicy); cb.Allow() {
+		t.Fatal("expected the breaker to be open after a chained node's failure, but it allowed a request")
+	}
+}
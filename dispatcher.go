@@ -2,42 +2,79 @@ package main
 
 import (
 	"bytes"
-	"crypto/tls"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
-	"os"
 	"sync"
+	"time"
 
+	"github.com/devthedeveloper/api-dispatcher/tlsconfig"
 	"github.com/quic-go/quic-go/http3"
 )
 
 type RequestConfig struct {
-	URL     string            `json:"url"`
-	Method  string            `json:"method"`
-	Headers map[string]string `json:"headers"`
-	Body    map[string]string `json:"body,omitempty"`
+	URL        string            `json:"url"`
+	Method     string            `json:"method"`
+	Headers    map[string]string `json:"headers"`
+	Body       map[string]string `json:"body,omitempty"`
+	TLSProfile string            `json:"tls_profile,omitempty"`
+	Protocol   string            `json:"protocol,omitempty"`
+	Policy     *Policy           `json:"policy,omitempty"`
+	Cache      *CachePolicy      `json:"cache,omitempty"`
+
+	// ID, DependsOn, and Extract opt a request into the sequential/DAG
+	// mode implemented in chain.go: set an ID on at least one request in
+	// the config to enable it.
+	ID        string            `json:"id,omitempty"`
+	DependsOn []string          `json:"depends_on,omitempty"`
+	Extract   map[string]string `json:"extract,omitempty"`
 }
 
 type Config struct {
 	Requests []RequestConfig `json:"requests"`
+	QPS      float64         `json:"qps,omitempty"`
 }
 
+// maxConcurrency and perHostConcurrency are the global and per-host
+// in-flight request caps applied to every dispatched config; they default
+// to unbounded (0) unless overridden by -max-concurrency/-max-per-host.
+var (
+	maxConcurrency     int
+	perHostConcurrency int
+)
+
+// maxRequestsPerConfig rejects configs that fan out to more requests than
+// this, so a single misconfigured or malicious payload can't spawn an
+// unbounded number of goroutines. Set via -max-requests.
+var maxRequestsPerConfig int
+
+// tlsStore is the set of named TLS profiles loaded via -tls-config, shared
+// by sendRequest to build per-upstream transports. It is nil when no
+// -tls-config flag was given, in which case requests fall back to Go's
+// default TLS behavior.
+var tlsStore *tlsconfig.Store
+
 func loadConfigFromBody(body []byte) (Config, error) {
 	var config Config
 	err := json.Unmarshal(body, &config)
 	return config, err
 }
 
-func sendRequest(config RequestConfig, wg *sync.WaitGroup, results chan<- string) {
-	defer wg.Done()
+// attemptRequest performs a single attempt at config, honoring the
+// policy's per-attempt timeout. The returned bool reports whether the
+// failure (if any) was a network/transport error, as opposed to an
+// application-level one already captured in the RequestResult.
+func attemptRequest(config RequestConfig, start time.Time) (RequestResult, bool, error) {
+	roundTripper, err := roundTripperFor(config)
+	if err != nil {
+		return RequestResult{}, false, fmt.Errorf("configuring transport: %w", err)
+	}
 
-	client := &http.Client{}
+	client := &http.Client{Transport: roundTripper, Timeout: config.Policy.timeout()}
 	var req *http.Request
-	var err error
 
 	if config.Method == "POST" && config.Body != nil {
 		bodyData, _ := json.Marshal(config.Body)
@@ -47,8 +84,7 @@ func sendRequest(config RequestConfig, wg *sync.WaitGroup, results chan<- string
 	}
 
 	if err != nil {
-		results <- fmt.Sprintf("Error creating request for %s: %v", config.URL, err)
-		return
+		return RequestResult{}, false, fmt.Errorf("creating request: %w", err)
 	}
 
 	for key, value := range config.Headers {
@@ -57,30 +93,135 @@ func sendRequest(config RequestConfig, wg *sync.WaitGroup, results chan<- string
 
 	resp, err := client.Do(req)
 	if err != nil {
-		results <- fmt.Sprintf("Error sending request to %s: %v", config.URL, err)
-		return
+		return RequestResult{}, true, fmt.Errorf("sending request: %w", err)
 	}
 	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
+	result, err := readResult(config, start, resp.StatusCode, resp.Header, resp.Body)
 	if err != nil {
-		results <- fmt.Sprintf("Error reading response from %s: %v", config.URL, err)
+		return RequestResult{}, true, fmt.Errorf("reading response: %w", err)
+	}
+
+	return result, false, nil
+}
+
+// performRequest runs config through its circuit breaker and retry/backoff
+// policy, attempting cachedRequest up to config.Policy.retries()+1 times,
+// and returns the final RequestResult with Attempts set. Callers are
+// expected to have already acquired a Limiter slot for config's host;
+// performRequest itself only sleeps between attempts and talks to the
+// breaker registry, so both the flat dispatch path (sendRequest) and the
+// DAG path (runChain, in chain.go) share it to get the same retry/breaker
+// behavior per node.
+func performRequest(config RequestConfig, start time.Time) RequestResult {
+	cb := breakers.get(hostOf(config.URL), config.Policy.circuitBreaker())
+
+	maxAttempts := config.Policy.retries() + 1
+	attemptsMade := 0
+	var result RequestResult
+	var attemptErr error
+
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(backoff(attempt - 2))
+		}
+
+		if cb != nil && !cb.Allow() {
+			attemptErr = fmt.Errorf("circuit breaker open for %s", hostOf(config.URL))
+			break
+		}
+
+		attemptsMade = attempt
+		var isNetworkErr bool
+		result, isNetworkErr, attemptErr = cachedRequest(config, start)
+
+		if attemptErr != nil {
+			if cb != nil {
+				cb.RecordFailure()
+			}
+			if isNetworkErr && config.Policy.retryableError() && attempt < maxAttempts {
+				continue
+			}
+			break
+		}
+
+		if cb != nil {
+			if result.StatusCode >= 500 {
+				cb.RecordFailure()
+			} else {
+				cb.RecordSuccess()
+			}
+		}
+
+		if config.Policy.retryableStatus(result.StatusCode) && attempt < maxAttempts {
+			continue
+		}
+
+		result.Attempts = attempt
+		return result
+	}
+
+	if attemptErr != nil {
+		errResult := newErrorResult(config, start, attemptErr)
+		errResult.Attempts = attemptsMade
+		return errResult
+	}
+
+	result.Attempts = attemptsMade
+	return result
+}
+
+func sendRequest(config RequestConfig, limiter *Limiter, wg *sync.WaitGroup, results chan<- RequestResult) {
+	defer wg.Done()
+
+	start := time.Now()
+
+	release := limiter.Acquire(hostOf(config.URL))
+	defer release()
+
+	results <- performRequest(config, start)
+}
+
+// dispatch fans Config.Requests out to sendRequest (or, for a chained
+// config, hands them to runChain) under a Limiter built from the global
+// flags plus the config's own QPS, and closes results once every request
+// has reported in.
+func dispatch(config Config, results chan<- RequestResult) {
+	limiter := NewLimiter(maxConcurrency, perHostConcurrency, config.QPS)
+	defer limiter.Stop()
+
+	if isChained(config) {
+		runChain(config, limiter, results)
 		return
 	}
 
-	result := fmt.Sprintf("Response from %s: %s", config.URL, string(body))
-	results <- result
+	var wg sync.WaitGroup
+	for _, reqConfig := range config.Requests {
+		wg.Add(1)
+		go sendRequest(reqConfig, limiter, &wg, results)
+	}
+
+	wg.Wait()
+	close(results)
 }
 
+// maxBodyBytes caps the size of an incoming config body the HTTP handler
+// will read, set via -max-body-bytes.
+var maxBodyBytes int64
+
 func handleAPIRequest(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Only POST method is supported", http.StatusMethodNotAllowed)
 		return
 	}
 
+	if maxBodyBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	}
+
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		http.Error(w, "Failed to read request body (it may exceed the size limit)", http.StatusBadRequest)
 		return
 	}
 
@@ -90,22 +231,33 @@ func handleAPIRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var wg sync.WaitGroup
-	results := make(chan string)
+	if maxRequestsPerConfig > 0 && len(config.Requests) > maxRequestsPerConfig {
+		http.Error(w, fmt.Sprintf("config has %d requests, exceeding the limit of %d", len(config.Requests), maxRequestsPerConfig), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+
+	results := make(chan RequestResult)
+	flusher, _ := w.(http.Flusher)
+	writeDone := make(chan struct{})
 
 	go func() {
+		defer close(writeDone)
 		for result := range results {
-			fmt.Fprintln(w, result)
+			line, err := result.NDJSON()
+			if err != nil {
+				continue
+			}
+			fmt.Fprintln(w, line)
+			if flusher != nil {
+				flusher.Flush()
+			}
 		}
 	}()
 
-	for _, reqConfig := range config.Requests {
-		wg.Add(1)
-		go sendRequest(reqConfig, &wg, results)
-	}
-
-	wg.Wait()
-	close(results)
+	dispatch(config, results)
+	<-writeDone
 }
 
 func main() {
@@ -113,47 +265,77 @@ func main() {
 	addr := flag.String("addr", ":8080", "HTTP/1.1 and HTTP/2 server address")
 	http3Addr := flag.String("http3-addr", ":8443", "HTTP/3 server address")
 	configFile := flag.String("config", "", "Path to the configuration file")
+	tlsConfigPath := flag.String("tls-config", "", "Path to a TLS profile file (YAML) defining client/server/peer profiles")
+	serverTLSProfile := flag.String("server-tls-profile", "server", "TLS profile (from -tls-config) for the HTTP/1.1+HTTP/2 server")
+	http3TLSProfile := flag.String("http3-tls-profile", "server", "TLS profile (from -tls-config) for the HTTP/3 server")
+	flag.IntVar(&maxConcurrency, "max-concurrency", 0, "Maximum in-flight dispatched requests across a run (0 = unbounded)")
+	flag.IntVar(&perHostConcurrency, "max-per-host", 0, "Maximum in-flight dispatched requests per upstream host (0 = unbounded)")
+	flag.IntVar(&maxRequestsPerConfig, "max-requests", 100, "Reject configs with more than this many requests (0 = unbounded)")
+	flag.Int64Var(&maxBodyBytes, "max-body-bytes", 10<<20, "Maximum size of a config body the HTTP handler will read (0 = unbounded)")
+	output := flag.String("output", "text", "CLI result format: text, json, or ndjson")
 	flag.Parse()
 
-	certPath := "cert.pem"
-	keyPath := "key.pem"
+	switch *output {
+	case "text", "json", "ndjson":
+	default:
+		log.Fatalf("invalid -output %q: must be text, json, or ndjson", *output)
+	}
+
+	if *tlsConfigPath != "" {
+		store, err := tlsconfig.Load(*tlsConfigPath)
+		if err != nil {
+			log.Fatalf("Error loading -tls-config: %v", err)
+		}
+		tlsStore = store
+	}
 
 	if *useHTTP3 {
-		// Debugging output
-		fmt.Println("Using cert.pem path:", certPath)
-		fmt.Println("Using key.pem path:", keyPath)
+		if tlsStore == nil {
+			log.Fatal("-http3 requires -tls-config to define a server profile")
+		}
 
-		if _, err := os.Stat(certPath); os.IsNotExist(err) {
-			log.Fatalf("cert.pem file does not exist at: %s", certPath)
+		serverProfile, ok := tlsStore.Profile(*serverTLSProfile)
+		if !ok {
+			log.Fatalf("unknown -server-tls-profile %q", *serverTLSProfile)
+		}
+		serverTLSCfg, err := serverProfile.Build(true)
+		if err != nil {
+			log.Fatalf("building -server-tls-profile %q: %v", *serverTLSProfile, err)
 		}
 
-		if _, err := os.Stat(keyPath); os.IsNotExist(err) {
-			log.Fatalf("key.pem file does not exist at: %s", keyPath)
+		http3Profile, ok := tlsStore.Profile(*http3TLSProfile)
+		if !ok {
+			log.Fatalf("unknown -http3-tls-profile %q", *http3TLSProfile)
+		}
+		http3TLSCfg, err := http3Profile.Build(true)
+		if err != nil {
+			log.Fatalf("building -http3-tls-profile %q: %v", *http3TLSProfile, err)
 		}
 
+		mux := http.NewServeMux()
+		mux.HandleFunc("/", handleAPIRequest)
+		mux.HandleFunc("/stats", handleStats)
+
 		// Start HTTP/1.1 and HTTP/2 server
 		go func() {
 			server := &http.Server{
-				Addr:    *addr,
-				Handler: http.HandlerFunc(handleAPIRequest),
+				Addr:      *addr,
+				Handler:   mux,
+				TLSConfig: serverTLSCfg,
 			}
 			log.Printf("Starting HTTP/1.1 and HTTP/2 server on %s", *addr)
-			log.Fatal(server.ListenAndServeTLS(certPath, keyPath))
+			log.Fatal(server.ListenAndServeTLS("", ""))
 		}()
 
 		// Start HTTP/3 server
-		tlsCert, err := tls.LoadX509KeyPair(certPath, keyPath)
-		if err != nil {
-			log.Fatalf("Failed to load TLS certificates: %v", err)
-		}
-
 		quicServer := &http3.Server{
 			Addr:      *http3Addr,
-			TLSConfig: &tls.Config{Certificates: []tls.Certificate{tlsCert}},
+			Handler:   mux,
+			TLSConfig: http3TLSCfg,
 		}
 
 		log.Printf("Starting HTTP/3 server on %s", *http3Addr)
-		log.Fatal(quicServer.ListenAndServeTLS(certPath, keyPath)) // Use correct paths here
+		log.Fatal(quicServer.ListenAndServeTLS("", ""))
 	} else if *configFile != "" {
 		// Run as a CLI tool
 		configData, err := ioutil.ReadFile(*configFile)
@@ -166,22 +348,13 @@ func main() {
 			log.Fatalf("Error loading config: %v", err)
 		}
 
-		var wg sync.WaitGroup
-		results := make(chan string)
+		results := make(chan RequestResult)
+		printDone := make(chan struct{})
 
-		go func() {
-			for result := range results {
-				fmt.Println(result)
-			}
-		}()
-
-		for _, reqConfig := range config.Requests {
-			wg.Add(1)
-			go sendRequest(reqConfig, &wg, results)
-		}
+		go printResults(*output, results, printDone)
 
-		wg.Wait()
-		close(results)
+		dispatch(config, results)
+		<-printDone
 	} else {
 		log.Fatal("Usage: api-dispatcher -config=<config-file> or api-dispatcher -http3")
 	}
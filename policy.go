@@ -0,0 +1,212 @@
+package main
+
+import (
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Policy configures per-request timeout, retry, and circuit-breaker
+// behavior. A nil Policy on a RequestConfig means "use defaults": no
+// per-attempt timeout beyond the client default, no retries, breaker
+// disabled.
+type Policy struct {
+	Timeout        string         `json:"timeout,omitempty"`
+	Retries        int            `json:"retries,omitempty"`
+	RetryOn        []string       `json:"retry_on,omitempty"`
+	CircuitBreaker *BreakerPolicy `json:"circuit_breaker,omitempty"`
+}
+
+// BreakerPolicy configures the per-host circuit breaker: after
+// FailureThreshold consecutive failures the breaker opens and rejects
+// further attempts until Cooldown has elapsed, at which point it goes
+// half-open and allows exactly one trial attempt through until that
+// trial reports success or failure.
+type BreakerPolicy struct {
+	FailureThreshold int    `json:"failure_threshold,omitempty"`
+	Cooldown         string `json:"cooldown,omitempty"`
+}
+
+// timeout parses Policy.Timeout, returning 0 if unset or invalid.
+func (p *Policy) timeout() time.Duration {
+	if p == nil || p.Timeout == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(p.Timeout)
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
+// retries returns the configured retry count, or 0 if no Policy is set.
+func (p *Policy) retries() int {
+	if p == nil {
+		return 0
+	}
+	return p.Retries
+}
+
+// circuitBreaker returns the configured breaker policy, or nil if no
+// Policy (or no breaker) is set.
+func (p *Policy) circuitBreaker() *BreakerPolicy {
+	if p == nil {
+		return nil
+	}
+	return p.CircuitBreaker
+}
+
+// retryableStatus reports whether a response status code qualifies for a
+// retry under retry_on.
+func (p *Policy) retryableStatus(statusCode int) bool {
+	if p == nil {
+		return false
+	}
+	code := strconv.Itoa(statusCode)
+	for _, entry := range p.RetryOn {
+		if entry == code {
+			return true
+		}
+	}
+	return false
+}
+
+// retryableError reports whether a network/transport error qualifies for a
+// retry under retry_on's "network_error" keyword.
+func (p *Policy) retryableError() bool {
+	if p == nil {
+		return false
+	}
+	for _, entry := range p.RetryOn {
+		if entry == "network_error" {
+			return true
+		}
+	}
+	return false
+}
+
+// maxBackoff caps the base of the computed exponential backoff (before
+// jitter), both to keep retries from sleeping absurdly long and to keep the
+// shift in backoff from overflowing time.Duration for a large configured
+// retries count.
+const maxBackoff = 30 * time.Second
+
+// backoff computes the exponential backoff with jitter for the given retry
+// attempt (0-indexed), based on a 100ms starting delay doubling each time,
+// capped at maxBackoff, plus a random jitter of up to half that base (so at
+// most 1.5x maxBackoff in total).
+func backoff(attempt int) time.Duration {
+	base := maxBackoff
+	if attempt < 16 { // 100ms<<16 is already well past maxBackoff
+		if shifted := 100 * time.Millisecond << attempt; shifted < maxBackoff {
+			base = shifted
+		}
+	}
+	jitter := time.Duration(rand.Int63n(int64(base)/2 + 1))
+	return base + jitter
+}
+
+// breakerState is the circuit breaker's current state.
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// breaker is a per-host circuit breaker: it opens after consecutive
+// failures and half-opens after a cooldown to let a single trial request
+// through.
+type breaker struct {
+	mu                  sync.Mutex
+	state               breakerState
+	consecutiveFailures int
+	threshold           int
+	cooldown            time.Duration
+	openedAt            time.Time
+}
+
+// Allow reports whether a request may proceed, transitioning an open
+// breaker to half-open once its cooldown has elapsed. Only the single
+// caller that performs that transition is let through; every other
+// caller that observes breakerHalfOpen is rejected until the in-flight
+// trial reports success (closing the breaker) or failure (reopening it).
+func (b *breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) >= b.cooldown {
+			b.state = breakerHalfOpen
+			return true
+		}
+		return false
+	case breakerHalfOpen:
+		return false
+	default:
+		return true
+	}
+}
+
+// RecordSuccess closes the breaker and resets its failure count.
+func (b *breaker) RecordSuccess() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.consecutiveFailures = 0
+	b.state = breakerClosed
+}
+
+// RecordFailure increments the failure count, opening the breaker once the
+// threshold is reached (including immediately from half-open).
+func (b *breaker) RecordFailure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerHalfOpen {
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+		return
+	}
+
+	b.consecutiveFailures++
+	if b.threshold > 0 && b.consecutiveFailures >= b.threshold {
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// breakerRegistry holds one breaker per host, shared across every config
+// that targets that upstream so failures observed by one run are honored
+// by the next.
+type breakerRegistry struct {
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+var breakers = &breakerRegistry{breakers: make(map[string]*breaker)}
+
+// get returns the breaker for host, creating it from policy on first use.
+// Returns nil if policy is nil (breaker disabled).
+func (r *breakerRegistry) get(host string, policy *BreakerPolicy) *breaker {
+	if policy == nil {
+		return nil
+	}
+
+	cooldown, err := time.ParseDuration(policy.Cooldown)
+	if err != nil {
+		cooldown = 30 * time.Second
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b, ok := r.breakers[host]
+	if !ok {
+		b = &breaker{threshold: policy.FailureThreshold, cooldown: cooldown}
+		r.breakers[host] = b
+	}
+	return b
+}
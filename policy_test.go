@@ -0,0 +1,63 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBackoffDoesNotOverflowOrPanic(t *testing.T) {
+	for _, attempt := range []int{0, 1, 5, 20, 56, 1000} {
+		d := backoff(attempt)
+		if d <= 0 {
+			t.Fatalf("backoff(%d) = %v, want a positive duration", attempt, d)
+		}
+		if d > 2*maxBackoff {
+			t.Fatalf("backoff(%d) = %v, want at most ~2x maxBackoff (%v)", attempt, d, maxBackoff)
+		}
+	}
+}
+
+// TestBreakerHalfOpenAllowsOnlyOneTrial guards against an open breaker
+// admitting unbounded concurrent trials: once cooled down, only the first
+// of several concurrent Allow() callers should see true.
+func TestBreakerHalfOpenAllowsOnlyOneTrial(t *testing.T) {
+	b := &breaker{state: breakerOpen, threshold: 1, cooldown: time.Millisecond, openedAt: time.Now().Add(-time.Second)}
+
+	const callers = 20
+	var admitted int64
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	wg.Add(callers)
+	for i := 0; i < callers; i++ {
+		go func() {
+			defer wg.Done()
+			if b.Allow() {
+				mu.Lock()
+				admitted++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if admitted != 1 {
+		t.Fatalf("admitted = %d concurrent half-open trials, want exactly 1", admitted)
+	}
+}
+
+func TestBreakerRecordSuccessClosesFromHalfOpen(t *testing.T) {
+	b := &breaker{state: breakerHalfOpen, threshold: 1, cooldown: time.Second}
+	b.RecordSuccess()
+	if !b.Allow() {
+		t.Fatal("expected a closed breaker to allow requests")
+	}
+}
+
+func TestBreakerRecordFailureReopensFromHalfOpen(t *testing.T) {
+	b := &breaker{state: breakerHalfOpen, threshold: 1, cooldown: time.Hour, openedAt: time.Now()}
+	b.RecordFailure()
+	if b.Allow() {
+		t.Fatal("expected a freshly reopened breaker (long cooldown) to reject requests")
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Limiter bounds how many dispatched requests may be in flight at once,
+// both globally and per upstream host, and optionally throttles the rate
+// at which new requests are allowed to start.
+type Limiter struct {
+	global chan struct{} // nil means no global cap
+
+	perHostCap int
+	mu         sync.Mutex
+	perHost    map[string]chan struct{}
+
+	ticker *time.Ticker // nil means no QPS throttle
+}
+
+// NewLimiter builds a Limiter. maxConcurrency <= 0 disables the global cap,
+// perHostCap <= 0 disables the per-host cap, and qps <= 0 disables QPS
+// throttling.
+func NewLimiter(maxConcurrency, perHostCap int, qps float64) *Limiter {
+	l := &Limiter{perHostCap: perHostCap, perHost: make(map[string]chan struct{})}
+
+	if maxConcurrency > 0 {
+		l.global = make(chan struct{}, maxConcurrency)
+	}
+
+	if qps > 0 {
+		interval := time.Duration(float64(time.Second) / qps)
+		if interval < time.Nanosecond {
+			interval = time.Nanosecond
+		}
+		l.ticker = time.NewTicker(interval)
+	}
+
+	return l
+}
+
+// Stop releases the underlying ticker, if any. Safe to call on a nil
+// Limiter.
+func (l *Limiter) Stop() {
+	if l != nil && l.ticker != nil {
+		l.ticker.Stop()
+	}
+}
+
+// Acquire blocks until a slot is available for a request to the given host,
+// honoring the QPS throttle, the global concurrency cap, and the per-host
+// cap, in that order. It returns a release func that must be called once
+// the request completes.
+func (l *Limiter) Acquire(host string) func() {
+	if l == nil {
+		return func() {}
+	}
+
+	if l.ticker != nil {
+		<-l.ticker.C
+	}
+
+	if l.global != nil {
+		l.global <- struct{}{}
+	}
+
+	hostSem := l.hostSem(host)
+	if hostSem != nil {
+		hostSem <- struct{}{}
+	}
+
+	return func() {
+		if hostSem != nil {
+			<-hostSem
+		}
+		if l.global != nil {
+			<-l.global
+		}
+	}
+}
+
+func (l *Limiter) hostSem(host string) chan struct{} {
+	if l.perHostCap <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	sem, ok := l.perHost[host]
+	if !ok {
+		sem = make(chan struct{}, l.perHostCap)
+		l.perHost[host] = sem
+	}
+	return sem
+}
+
+// hostOf extracts the host:port used for per-host rate limiting from a
+// request URL, falling back to the raw URL if it doesn't parse.
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+	return u.Host
+}
@@ -0,0 +1,15 @@
+package main
+
+import "testing"
+
+// TestNewLimiterHighQPSDoesNotPanic guards against a config-supplied qps
+// large enough that the computed ticker interval truncates to 0, which
+// used to panic time.NewTicker with "non-positive interval".
+func TestNewLimiterHighQPSDoesNotPanic(t *testing.T) {
+	for _, qps := range []float64{1e9, 1e12, 1e300} {
+		func() {
+			l := NewLimiter(0, 0, qps)
+			defer l.Stop()
+		}()
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+	"unicode/utf8"
+)
+
+// maxCapturedBodyBytes bounds how much of an upstream response body a
+// RequestResult will hold, so one huge response can't blow out memory or
+// an NDJSON line.
+const maxCapturedBodyBytes = 1 << 20 // 1MiB
+
+// RequestResult is the structured outcome of a single dispatched request,
+// replacing the old plain-string result stream so callers can inspect
+// status, timing, and headers programmatically instead of scraping text.
+type RequestResult struct {
+	URL        string              `json:"url"`
+	Method     string              `json:"method"`
+	StatusCode int                 `json:"status_code,omitempty"`
+	ElapsedMS  int64               `json:"elapsed_ms"`
+	BytesRead  int                 `json:"bytes_read"`
+	Headers    map[string][]string `json:"headers,omitempty"`
+	Body       string              `json:"body,omitempty"`
+	BodyBase64 bool                `json:"body_base64,omitempty"`
+	Truncated  bool                `json:"truncated,omitempty"`
+	Error      string              `json:"error,omitempty"`
+	Attempts   int                 `json:"attempts,omitempty"`
+}
+
+// newErrorResult builds a RequestResult for a request that failed before
+// (or while) reading a response.
+func newErrorResult(config RequestConfig, start time.Time, err error) RequestResult {
+	return RequestResult{
+		URL:       config.URL,
+		Method:    config.Method,
+		ElapsedMS: time.Since(start).Milliseconds(),
+		Error:     err.Error(),
+	}
+}
+
+// readResult captures a response body up to maxCapturedBodyBytes, encoding
+// it as base64 if it isn't valid UTF-8 so it survives JSON encoding
+// losslessly.
+func readResult(config RequestConfig, start time.Time, statusCode int, headers map[string][]string, body io.Reader) (RequestResult, error) {
+	limited := io.LimitReader(body, maxCapturedBodyBytes+1)
+	data, err := io.ReadAll(limited)
+	if err != nil {
+		return RequestResult{}, err
+	}
+
+	truncated := len(data) > maxCapturedBodyBytes
+	if truncated {
+		data = data[:maxCapturedBodyBytes]
+	}
+
+	result := RequestResult{
+		URL:        config.URL,
+		Method:     config.Method,
+		StatusCode: statusCode,
+		ElapsedMS:  time.Since(start).Milliseconds(),
+		BytesRead:  len(data),
+		Headers:    headers,
+		Truncated:  truncated,
+	}
+
+	if utf8.Valid(data) {
+		result.Body = string(data)
+	} else {
+		result.Body = base64.StdEncoding.EncodeToString(data)
+		result.BodyBase64 = true
+	}
+
+	return result, nil
+}
+
+// Text renders a RequestResult as a one-line human-readable summary
+// (method, URL, status, elapsed time, and bytes read, or the error), for
+// -output=text.
+func (r RequestResult) Text() string {
+	if r.Error != "" {
+		return fmt.Sprintf("Error dispatching %s %s: %s", r.Method, r.URL, r.Error)
+	}
+	return fmt.Sprintf("%s %s -> %d (%dms, %d bytes)", r.Method, r.URL, r.StatusCode, r.ElapsedMS, r.BytesRead)
+}
+
+// NDJSON renders a RequestResult as a single JSON line.
+func (r RequestResult) NDJSON() (string, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// printResults drains results to stdout in the CLI's chosen -output
+// format: one line of text or NDJSON per result as it arrives, or a single
+// indented JSON array once every result is in. Callers must wait for done
+// to close before exiting, since (especially for -output=json) nothing is
+// printed until results is closed and printResults has had a chance to run.
+func printResults(format string, results <-chan RequestResult, done chan<- struct{}) {
+	defer close(done)
+
+	switch format {
+	case "ndjson":
+		for r := range results {
+			line, err := r.NDJSON()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "error encoding result:", err)
+				continue
+			}
+			fmt.Println(line)
+		}
+	case "json":
+		var all []RequestResult
+		for r := range results {
+			all = append(all, r)
+		}
+		data, err := json.MarshalIndent(all, "", "  ")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error encoding results:", err)
+			return
+		}
+		fmt.Println(string(data))
+	default:
+		for r := range results {
+			fmt.Println(r.Text())
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+// TestPrintResultsJSONWaitsForConsumer guards against the json-output-loss
+// bug: -output=json only marshals and prints after results closes, so a
+// caller that doesn't wait on done can exit before anything is written.
+func TestPrintResultsJSONWaitsForConsumer(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	origStdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = origStdout }()
+
+	results := make(chan RequestResult, 1)
+	done := make(chan struct{})
+
+	go printResults("json", results, done)
+
+	results <- RequestResult{URL: "http://example.com", Method: "GET", StatusCode: 200}
+	close(results)
+	<-done // callers that skip this wait can race past printResults entirely
+
+	w.Close()
+	os.Stdout = origStdout
+
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(r); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []RequestResult
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("expected valid JSON output, got %q: %v", buf.String(), err)
+	}
+	if len(got) != 1 || got[0].URL != "http://example.com" {
+		t.Fatalf("unexpected results: %+v", got)
+	}
+}
+
+func TestPrintResultsClosesDoneForTextAndNDJSON(t *testing.T) {
+	for _, format := range []string{"text", "ndjson"} {
+		results := make(chan RequestResult, 1)
+		done := make(chan struct{})
+
+		go printResults(format, results, done)
+
+		results <- RequestResult{URL: "http://example.com", Method: "GET", StatusCode: 200}
+		close(results)
+		<-done
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/net/http2"
+)
+
+// roundTripperCache holds one http.RoundTripper per (protocol, tls_profile)
+// pair so that repeated requests reuse connections, which matters
+// especially for HTTP/3 where a fresh *http3.RoundTripper means a fresh
+// QUIC handshake.
+var roundTripperCache = struct {
+	mu    sync.Mutex
+	cache map[string]http.RoundTripper
+}{cache: make(map[string]http.RoundTripper)}
+
+// tlsConfigForProfile builds a *tls.Config from a named tls_profile, or
+// returns nil if no profile is set.
+func tlsConfigForProfile(profileName string) (*tls.Config, error) {
+	if profileName == "" {
+		return nil, nil
+	}
+
+	profile, ok := tlsStore.Profile(profileName)
+	if !ok {
+		return nil, fmt.Errorf("unknown tls_profile %q", profileName)
+	}
+
+	return profile.Build(false)
+}
+
+// roundTripperFor returns the round tripper to dispatch config through,
+// selecting a default http.Transport, explicitly-configured HTTP/2, or
+// HTTP/3 (QUIC) based on config.Protocol ("", "http2", or "http3"), and
+// reusing one instance per (protocol, tls_profile) pair. The default
+// transport negotiates HTTP/2 over TLS on its own only when no tls_profile
+// is set; with one set, it speaks HTTP/1.1.
+func roundTripperFor(config RequestConfig) (http.RoundTripper, error) {
+	key := config.Protocol + "|" + config.TLSProfile
+
+	roundTripperCache.mu.Lock()
+	if rt, ok := roundTripperCache.cache[key]; ok {
+		roundTripperCache.mu.Unlock()
+		return rt, nil
+	}
+	roundTripperCache.mu.Unlock()
+
+	tlsCfg, err := tlsConfigForProfile(config.TLSProfile)
+	if err != nil {
+		return nil, fmt.Errorf("building tls_profile %q: %w", config.TLSProfile, err)
+	}
+
+	var rt http.RoundTripper
+	switch config.Protocol {
+	case "http3":
+		rt = &http3.RoundTripper{TLSClientConfig: tlsCfg}
+	case "http2":
+		transport := &http.Transport{TLSClientConfig: tlsCfg}
+		if err := http2.ConfigureTransport(transport); err != nil {
+			return nil, fmt.Errorf("configuring http2: %w", err)
+		}
+		rt = transport
+	default:
+		rt = &http.Transport{TLSClientConfig: tlsCfg}
+	}
+
+	roundTripperCache.mu.Lock()
+	roundTripperCache.cache[key] = rt
+	roundTripperCache.mu.Unlock()
+
+	return rt, nil
+}